@@ -0,0 +1,107 @@
+/*
+ *
+ * Copyright 2020 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// backendName selects which TransportBackend emits client/server wiring for
+// generated services. The default, "grpc-go", reproduces this plugin's
+// original output. Other values point the same generated interfaces at
+// alternate RPC runtimes.
+var backendName = flag.String("backend", "grpc-go", "transport backend to target: grpc-go or interceptor")
+
+// TransportBackend owns the transport-specific parts of generated code: the
+// client connection and call-option types, the unary client call, service
+// registration, the "not implemented" error and the compatibility
+// assertion. Not every grpc-go reference goes through it: genClientMethod
+// emits a grpc.StreamDesc variable for every method, genClient's
+// DefaultFooClientDialOption uses grpc.DialOption, and genMethodHandler's
+// handlers take a grpc.UnaryServerInterceptor or grpc.ServerStream and build
+// a grpc.UnaryServerInfo, whatever the backend. Streaming support is left
+// out of this interface: stream client/server types still reference
+// grpc.ClientStream/grpc.ServerStream directly, so a backend whose
+// SupportsStreaming returns false is rejected (via checkStreamingSupport)
+// before it can emit broken output for a streaming method.
+type TransportBackend interface {
+	// ConnType is the Go type of the field backing a generated client, in
+	// place of grpc.ClientConnInterface.
+	ConnType(g *protogen.GeneratedFile) string
+	// CallOptionType is the Go type of the variadic opts parameter on client
+	// methods, in place of grpc.CallOption.
+	CallOptionType(g *protogen.GeneratedFile) string
+	// Invoke emits the body of a unary client call. out and in are already
+	// declared by the caller; Invoke must declare err.
+	Invoke(g *protogen.GeneratedFile, sname string)
+	// EmitRegister emits the complete RegisterFooService function wiring a
+	// *FooService into this backend's registrar.
+	EmitRegister(g *protogen.GeneratedFile, file *protogen.File, service *protogen.Service)
+	// Unimplemented emits a return statement producing this backend's
+	// "method not implemented" error. nilArg is "nil," for handlers that
+	// must return two values, or "" for handlers that return only error.
+	Unimplemented(g *protogen.GeneratedFile, nilArg string, method *protogen.Method)
+	// SupportPackageAssertion emits the compile-time compatibility
+	// assertion for this backend, if it has one.
+	SupportPackageAssertion(g *protogen.GeneratedFile)
+	// EmitPreamble emits any shared type declarations this backend's
+	// generated code depends on. file identifies the Go package being
+	// generated into; implementations that declare package-level types must
+	// only emit them once per file.GoImportPath, since EmitPreamble runs once
+	// per proto input file and several inputs commonly share a Go package.
+	EmitPreamble(g *protogen.GeneratedFile, file *protogen.File)
+	// SupportsStreaming reports whether this backend can emit streaming
+	// client/server code. Backends that can't must not be handed a service
+	// with a streaming method; checkStreamingSupport enforces this.
+	SupportsStreaming() bool
+}
+
+// selectedBackend resolves -backend to a TransportBackend, defaulting to
+// grpc-go for unset or unrecognized values so existing invocations of the
+// plugin keep producing their current output.
+func selectedBackend() TransportBackend {
+	switch *backendName {
+	case "interceptor":
+		return interceptorBackend{}
+	default:
+		return grpcGoBackend{}
+	}
+}
+
+// checkStreamingSupport reports whether every method in file's services is
+// compatible with backend, reporting a plugin error and returning false at
+// the first streaming method found on a backend that can't support it
+// instead of letting generation continue into broken output.
+func checkStreamingSupport(gen *protogen.Plugin, file *protogen.File, backend TransportBackend) bool {
+	if backend.SupportsStreaming() {
+		return true
+	}
+	for _, service := range file.Services {
+		for _, method := range service.Methods {
+			if method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer() {
+				gen.Error(fmt.Errorf("-backend=%s does not support streaming RPCs, but %s.%s is streaming", *backendName, service.Desc.FullName(), method.Desc.Name()))
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,420 @@
+/*
+ *
+ * Copyright 2020 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	ioPackage        = protogen.GoImportPath("io")
+	strconvPackage   = protogen.GoImportPath("strconv")
+	stringsPackage   = protogen.GoImportPath("strings")
+	httpPackage      = protogen.GoImportPath("net/http")
+	protojsonPackage = protogen.GoImportPath("google.golang.org/protobuf/encoding/protojson")
+)
+
+// httpStatusFromCodeEmitted tracks which Go packages have already received a
+// httpStatusFromCode declaration, since generateGatewayFile runs once per
+// proto input file but multiple proto files commonly share one Go package,
+// and the function must only be declared once per package or the package
+// fails to compile with "redeclared in this block".
+var httpStatusFromCodeEmitted = map[protogen.GoImportPath]bool{}
+
+// httpGateway, when set, makes generateGatewayFile emit a _grpc.gw.go sibling
+// that transcodes HTTP/JSON requests into the unary RPCs of the file that
+// carry a google.api.http method option, without requiring grpc-gateway.
+var httpGateway = flag.Bool("http_gateway", false, "emit an HTTP/JSON transcoding gateway alongside the gRPC stubs")
+
+// httpRule is the subset of a google.api.http annotation this gateway
+// understands: a verb, a path template and an optional body selector.
+type httpRule struct {
+	verb string
+	path string
+	body string
+}
+
+// httpRuleForMethod extracts the primary google.api.http rule attached to a
+// method, if any. additional_bindings are not supported.
+func httpRuleForMethod(method *protogen.Method) (httpRule, bool) {
+	opts, ok := method.Desc.Options().(*descriptorpb.MethodOptions)
+	if !ok || opts == nil || !proto.HasExtension(opts, annotations.E_Http) {
+		return httpRule{}, false
+	}
+	rule, ok := proto.GetExtension(opts, annotations.E_Http).(*annotations.HttpRule)
+	if !ok || rule == nil {
+		return httpRule{}, false
+	}
+	switch p := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		return httpRule{verb: "GET", path: p.Get, body: rule.GetBody()}, true
+	case *annotations.HttpRule_Put:
+		return httpRule{verb: "PUT", path: p.Put, body: rule.GetBody()}, true
+	case *annotations.HttpRule_Post:
+		return httpRule{verb: "POST", path: p.Post, body: rule.GetBody()}, true
+	case *annotations.HttpRule_Delete:
+		return httpRule{verb: "DELETE", path: p.Delete, body: rule.GetBody()}, true
+	case *annotations.HttpRule_Patch:
+		return httpRule{verb: "PATCH", path: p.Patch, body: rule.GetBody()}, true
+	default:
+		return httpRule{}, false
+	}
+}
+
+// pathSegment is one '/'-separated component of a path template: either a
+// literal, a single-segment {field} capture, or a multi-segment {field=**}
+// capture (which must be the last segment).
+type pathSegment struct {
+	literal string
+	field   string
+	multi   bool
+}
+
+// parsePathTemplate splits a google.api.http path template into its
+// verb suffix (the ":action" after the last segment, if any) and its
+// slash-separated segments.
+func parsePathTemplate(path string) (segments []pathSegment, verb string) {
+	if idx := strings.LastIndex(path, ":"); idx >= 0 && !strings.Contains(path[idx:], "/") {
+		verb = path[idx+1:]
+		path = path[:idx]
+	}
+	for _, part := range splitPathSegments(strings.Trim(path, "/")) {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			inner := part[1 : len(part)-1]
+			field, multi := inner, false
+			if eq := strings.Index(inner, "="); eq >= 0 {
+				field, multi = inner[:eq], inner[eq+1:] == "**"
+			}
+			segments = append(segments, pathSegment{field: field, multi: multi})
+			continue
+		}
+		segments = append(segments, pathSegment{literal: part})
+	}
+	return segments, verb
+}
+
+// splitPathSegments splits path on '/', like strings.Split, except a '/'
+// inside a {...} capture does not start a new segment. This is needed for
+// captures like "{parent=shelves/*}", a common google.api.http pattern that
+// constrains a capture to a literal sub-path.
+func splitPathSegments(path string) []string {
+	var segments []string
+	depth, start := 0, 0
+	for i, r := range path {
+		switch r {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '/':
+			if depth == 0 {
+				segments = append(segments, path[start:i])
+				start = i + 1
+			}
+		}
+	}
+	segments = append(segments, path[start:])
+	return segments
+}
+
+// fieldParam turns a (possibly dotted, for nested message fields) field path
+// into a name usable as an http.ServeMux wildcard, since ServeMux patterns
+// don't allow dots in wildcard names.
+func fieldParam(field string) string {
+	return strings.ReplaceAll(field, ".", "_")
+}
+
+// muxPattern renders segments/verb as an http.ServeMux pattern. A verb fused
+// onto a literal last segment (e.g. "messages:send") is valid ServeMux syntax
+// as-is. A verb fused onto a field capture (e.g. "{message_id}:send") is not
+// representable as a ServeMux wildcard, so in that case the wildcard is left
+// bare and the generated handler peels the ":send" suffix off the captured
+// value itself; verbOnField reports when that peeling is required.
+func muxPattern(segments []pathSegment, verb string) (pattern string, verbOnField bool) {
+	parts := make([]string, len(segments))
+	for i, seg := range segments {
+		switch {
+		case seg.field != "" && seg.multi:
+			parts[i] = "{" + fieldParam(seg.field) + "...}"
+		case seg.field != "":
+			parts[i] = "{" + fieldParam(seg.field) + "}"
+		default:
+			parts[i] = seg.literal
+		}
+	}
+	last := len(segments) - 1
+	if verb != "" && last >= 0 && segments[last].field == "" {
+		parts[last] += ":" + verb
+		verb = ""
+	}
+	pattern = "/" + strings.Join(parts, "/")
+	return pattern, verb != ""
+}
+
+// generateGatewayFile generates a _grpc.gw.go file translating google.api.http
+// annotated unary RPCs in file into http.Handler registrations on a
+// *http.ServeMux, so that callers get a REST facade without grpc-gateway.
+func generateGatewayFile(gen *protogen.Plugin, file *protogen.File) *protogen.GeneratedFile {
+	if !*httpGateway || len(file.Services) == 0 {
+		return nil
+	}
+	if !fileHasHTTPRules(file) {
+		return nil
+	}
+	filename := file.GeneratedFilenamePrefix + "_grpc.gw.go"
+	g := gen.NewGeneratedFile(filename, file.GoImportPath)
+	g.P("// Code generated by protoc-gen-go-grpc. DO NOT EDIT.")
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+	if !httpStatusFromCodeEmitted[file.GoImportPath] {
+		httpStatusFromCodeEmitted[file.GoImportPath] = true
+		g.P("// httpStatusFromCode translates a gRPC status code into the HTTP status")
+		g.P("// code grpc-gateway conventionally maps it to.")
+		g.P("func httpStatusFromCode(c ", codesPackage.Ident("Code"), ") int {")
+		g.P("switch c {")
+		for _, m := range []struct {
+			code, status string
+		}{
+			{"OK", "StatusOK"},
+			{"Canceled", "StatusRequestTimeout"},
+			{"InvalidArgument", "StatusBadRequest"},
+			{"DeadlineExceeded", "StatusGatewayTimeout"},
+			{"NotFound", "StatusNotFound"},
+			{"AlreadyExists", "StatusConflict"},
+			{"PermissionDenied", "StatusForbidden"},
+			{"Unauthenticated", "StatusUnauthorized"},
+			{"ResourceExhausted", "StatusTooManyRequests"},
+			{"FailedPrecondition", "StatusBadRequest"},
+			{"Aborted", "StatusConflict"},
+			{"OutOfRange", "StatusBadRequest"},
+			{"Unimplemented", "StatusNotImplemented"},
+			{"Unavailable", "StatusServiceUnavailable"},
+		} {
+			g.P("case ", codesPackage.Ident(m.code), ": return ", httpPackage.Ident(m.status))
+		}
+		g.P("default: return ", httpPackage.Ident("StatusInternalServerError"))
+		g.P("}")
+		g.P("}")
+		g.P()
+	}
+	for _, service := range file.Services {
+		genGatewayService(g, service)
+	}
+	return g
+}
+
+func fileHasHTTPRules(file *protogen.File) bool {
+	for _, service := range file.Services {
+		for _, method := range service.Methods {
+			if _, ok := httpRuleForMethod(method); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func genGatewayService(g *protogen.GeneratedFile, service *protogen.Service) {
+	var bound []*protogen.Method
+	for _, method := range service.Methods {
+		if method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer() {
+			continue
+		}
+		if _, ok := httpRuleForMethod(method); ok {
+			bound = append(bound, method)
+		}
+	}
+	if len(bound) == 0 {
+		return
+	}
+
+	g.P("// Register", service.GoName, "HandlerServer registers HTTP handlers on mux for the")
+	g.P("// unary methods of ", service.GoName, " that carry a google.api.http option,")
+	g.P("// transcoding JSON request/response bodies and path/query parameters to and")
+	g.P("// from ", service.GoName, "Service.")
+	g.P("func Register", service.GoName, "HandlerServer(mux *", httpPackage.Ident("ServeMux"), ", srv *", service.GoName, "Service) {")
+	for _, method := range bound {
+		rule, _ := httpRuleForMethod(method)
+		segments, verb := parsePathTemplate(rule.path)
+		pattern, _ := muxPattern(segments, verb)
+		g.P(`mux.HandleFunc("`, rule.verb, " ", pattern, `", `, unexport(service.GoName), method.GoName, `Handler(srv))`)
+	}
+	g.P("}")
+	g.P()
+
+	for _, method := range bound {
+		genGatewayMethodHandler(g, service, method)
+	}
+}
+
+// resolveFieldPath resolves a (possibly dotted) field path against msg,
+// descending into nested message fields for each segment after the first.
+// It returns the chain of fields from msg down to the leaf, or nil if any
+// segment doesn't name a field of the message reached so far.
+func resolveFieldPath(msg *protogen.Message, path string) []*protogen.Field {
+	var chain []*protogen.Field
+	for _, name := range strings.Split(path, ".") {
+		if msg == nil {
+			return nil
+		}
+		var field *protogen.Field
+		for _, f := range msg.Fields {
+			if string(f.Desc.Name()) == name {
+				field = f
+				break
+			}
+		}
+		if field == nil {
+			return nil
+		}
+		chain = append(chain, field)
+		msg = field.Message
+	}
+	return chain
+}
+
+// emitFieldPathInit emits a nil-check/allocation for every field in chain
+// (the message-typed fields leading up to, but not including, a path's leaf
+// field), and returns the Go expression for the message the chain reaches.
+func emitFieldPathInit(g *protogen.GeneratedFile, chain []*protogen.Field) string {
+	expr := "in"
+	for _, f := range chain {
+		expr += "." + f.GoName
+		g.P("if ", expr, " == nil { ", expr, " = new(", f.Message.GoIdent, ") }")
+	}
+	return expr
+}
+
+func genGatewayMethodHandler(g *protogen.GeneratedFile, service *protogen.Service, method *protogen.Method) {
+	rule, _ := httpRuleForMethod(method)
+	segments, verb := parsePathTemplate(rule.path)
+	_, verbOnField := muxPattern(segments, verb)
+
+	pathFields := map[string]bool{}
+	for _, seg := range segments {
+		if seg.field != "" {
+			pathFields[strings.SplitN(seg.field, ".", 2)[0]] = true
+		}
+	}
+
+	g.P("func ", unexport(service.GoName), method.GoName, "Handler(srv *", service.GoName, "Service) ", httpPackage.Ident("HandlerFunc"), " {")
+	g.P("return func(w ", httpPackage.Ident("ResponseWriter"), ", r *", httpPackage.Ident("Request"), ") {")
+	g.P("in := new(", method.Input.GoIdent, ")")
+
+	switch rule.body {
+	case "*":
+		g.P("b, err := ", ioPackage.Ident("ReadAll"), "(r.Body)")
+		g.P("if err != nil { ", httpPackage.Ident("Error"), "(w, err.Error(), ", httpPackage.Ident("StatusBadRequest"), "); return }")
+		g.P("if err := ", protojsonPackage.Ident("Unmarshal"), "(b, in); err != nil { ", httpPackage.Ident("Error"), "(w, err.Error(), ", httpPackage.Ident("StatusBadRequest"), "); return }")
+	case "":
+		// No body: every field comes from the path or the query string.
+	default:
+		if chain := resolveFieldPath(method.Input, rule.body); chain != nil {
+			leaf := chain[len(chain)-1]
+			setter := emitFieldPathInit(g, chain[:len(chain)-1]) + "." + leaf.GoName
+			g.P("b, err := ", ioPackage.Ident("ReadAll"), "(r.Body)")
+			g.P("if err != nil { ", httpPackage.Ident("Error"), "(w, err.Error(), ", httpPackage.Ident("StatusBadRequest"), "); return }")
+			g.P(setter, " = new(", leaf.Message.GoIdent, ")")
+			g.P("if err := ", protojsonPackage.Ident("Unmarshal"), "(b, ", setter, "); err != nil { ", httpPackage.Ident("Error"), "(w, err.Error(), ", httpPackage.Ident("StatusBadRequest"), "); return }")
+		}
+	}
+
+	for _, seg := range segments {
+		if seg.field == "" {
+			continue
+		}
+		chain := resolveFieldPath(method.Input, seg.field)
+		if chain == nil {
+			continue
+		}
+		leaf := chain[len(chain)-1]
+		raw := "r.PathValue(\"" + fieldParam(seg.field) + "\")"
+		if verbOnField && seg.field == segments[len(segments)-1].field {
+			g.P("raw := ", raw)
+			g.P("if !", stringsPackage.Ident("HasSuffix"), `(raw, ":`, verb, `") { `, httpPackage.Ident("NotFound"), "(w, r); return }")
+			g.P(`raw = `, stringsPackage.Ident("TrimSuffix"), `(raw, ":`, verb, `")`)
+			raw = "raw"
+		}
+		setter := emitFieldPathInit(g, chain[:len(chain)-1]) + "." + leaf.GoName
+		genScalarAssign(g, leaf.Desc.Kind(), setter, raw)
+	}
+
+	if rule.body != "*" {
+		for _, field := range method.Input.Fields {
+			name := string(field.Desc.Name())
+			if pathFields[name] || (rule.body != "" && name == rule.body) {
+				continue
+			}
+			if field.Desc.IsList() || field.Desc.Kind() == protoreflect.MessageKind {
+				continue
+			}
+			g.P("if v := r.URL.Query().Get(", strconv.Quote(name), "); v != \"\" {")
+			genScalarAssign(g, field.Desc.Kind(), "in."+field.GoName, "v")
+			g.P("}")
+		}
+	}
+
+	g.P("out, err := srv.", method.GoName, "(r.Context(), in)")
+	g.P("if err != nil {")
+	g.P("st, _ := ", statusPackage.Ident("FromError"), "(err)")
+	g.P(httpPackage.Ident("Error"), "(w, st.Message(), httpStatusFromCode(st.Code()))")
+	g.P("return")
+	g.P("}")
+	g.P("b, err := ", protojsonPackage.Ident("Marshal"), "(out)")
+	g.P("if err != nil { ", httpPackage.Ident("Error"), "(w, err.Error(), ", httpPackage.Ident("StatusInternalServerError"), "); return }")
+	g.P(`w.Header().Set("Content-Type", "application/json")`)
+	g.P("w.Write(b)")
+	g.P("}")
+	g.P("}")
+	g.P()
+}
+
+// genScalarAssign emits code assigning the value of the Go expression raw
+// (a string) into the Go expression setter, parsing it according to kind.
+// Message, enum, and bytes fields are not bindable from path/query and are
+// left unset.
+func genScalarAssign(g *protogen.GeneratedFile, kind protoreflect.Kind, setter, raw string) {
+	switch kind {
+	case protoreflect.StringKind:
+		g.P(setter, " = ", raw)
+	case protoreflect.BoolKind:
+		g.P("if v, err := ", strconvPackage.Ident("ParseBool"), "(", raw, "); err == nil { ", setter, " = v }")
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		g.P("if v, err := ", strconvPackage.Ident("ParseInt"), "(", raw, ", 10, 32); err == nil { ", setter, " = int32(v) }")
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		g.P("if v, err := ", strconvPackage.Ident("ParseInt"), "(", raw, ", 10, 64); err == nil { ", setter, " = v }")
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		g.P("if v, err := ", strconvPackage.Ident("ParseUint"), "(", raw, ", 10, 32); err == nil { ", setter, " = uint32(v) }")
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		g.P("if v, err := ", strconvPackage.Ident("ParseUint"), "(", raw, ", 10, 64); err == nil { ", setter, " = v }")
+	case protoreflect.FloatKind:
+		g.P("if v, err := ", strconvPackage.Ident("ParseFloat"), "(", raw, ", 32); err == nil { ", setter, " = float32(v) }")
+	case protoreflect.DoubleKind:
+		g.P("if v, err := ", strconvPackage.Ident("ParseFloat"), "(", raw, ", 64); err == nil { ", setter, " = v }")
+	}
+}
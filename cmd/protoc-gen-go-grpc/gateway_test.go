@@ -0,0 +1,156 @@
+/*
+ *
+ * Copyright 2020 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestParsePathTemplate(t *testing.T) {
+	tests := []struct {
+		path     string
+		segments []pathSegment
+		verb     string
+	}{
+		{
+			path:     "/v1/messages",
+			segments: []pathSegment{{literal: "v1"}, {literal: "messages"}},
+		},
+		{
+			path:     "/v1/{name}",
+			segments: []pathSegment{{literal: "v1"}, {field: "name"}},
+		},
+		{
+			path:     "/v1/{resource.name}",
+			segments: []pathSegment{{literal: "v1"}, {field: "resource.name"}},
+		},
+		{
+			path:     "/v1/{parent=shelves/*}/books",
+			segments: []pathSegment{{literal: "v1"}, {field: "parent"}, {literal: "books"}},
+		},
+		{
+			path:     "/v1/{name=**}",
+			segments: []pathSegment{{literal: "v1"}, {field: "name", multi: true}},
+		},
+		{
+			path:     "/v1/messages/{message_id}:send",
+			segments: []pathSegment{{literal: "v1"}, {literal: "messages"}, {field: "message_id"}},
+			verb:     "send",
+		},
+	}
+	for _, tt := range tests {
+		segments, verb := parsePathTemplate(tt.path)
+		if !reflect.DeepEqual(segments, tt.segments) || verb != tt.verb {
+			t.Errorf("parsePathTemplate(%q) = %v, %q, want %v, %q", tt.path, segments, verb, tt.segments, tt.verb)
+		}
+	}
+}
+
+func TestMuxPattern(t *testing.T) {
+	tests := []struct {
+		name        string
+		segments    []pathSegment
+		verb        string
+		pattern     string
+		verbOnField bool
+	}{
+		{
+			name:     "literal verb fuses onto the literal segment",
+			segments: []pathSegment{{literal: "v1"}, {literal: "messages"}},
+			verb:     "send",
+			pattern:  "/v1/messages:send",
+		},
+		{
+			name:        "verb on a field capture is left for runtime peeling",
+			segments:    []pathSegment{{literal: "v1"}, {field: "message_id"}},
+			verb:        "send",
+			pattern:     "/v1/{message_id}",
+			verbOnField: true,
+		},
+		{
+			name:     "multi-segment capture",
+			segments: []pathSegment{{literal: "v1"}, {field: "name", multi: true}},
+			pattern:  "/v1/{name...}",
+		},
+		{
+			name:     "dotted field name becomes a valid wildcard",
+			segments: []pathSegment{{literal: "v1"}, {field: "resource.name"}},
+			pattern:  "/v1/{resource_name}",
+		},
+	}
+	for _, tt := range tests {
+		pattern, verbOnField := muxPattern(tt.segments, tt.verb)
+		if pattern != tt.pattern || verbOnField != tt.verbOnField {
+			t.Errorf("%s: muxPattern() = %q, %v, want %q, %v", tt.name, pattern, verbOnField, tt.pattern, tt.verbOnField)
+		}
+	}
+}
+
+// fakeFieldDescriptor stubs just enough of protoreflect.FieldDescriptor for
+// resolveFieldPath, which only calls Name(). Embedding the (nil) interface
+// satisfies the rest of the method set without ever invoking it.
+type fakeFieldDescriptor struct {
+	protoreflect.FieldDescriptor
+	name protoreflect.Name
+}
+
+func (f fakeFieldDescriptor) Name() protoreflect.Name { return f.name }
+
+func fakeField(name, goName string, msg *protogen.Message) *protogen.Field {
+	return &protogen.Field{
+		Desc:    fakeFieldDescriptor{name: protoreflect.Name(name)},
+		GoName:  goName,
+		Message: msg,
+	}
+}
+
+func TestResolveFieldPath(t *testing.T) {
+	inner := &protogen.Message{
+		Fields: []*protogen.Field{
+			fakeField("name", "Name", nil),
+		},
+	}
+	outer := &protogen.Message{
+		Fields: []*protogen.Field{
+			fakeField("resource", "Resource", inner),
+			fakeField("id", "Id", nil),
+		},
+	}
+
+	chain := resolveFieldPath(outer, "resource.name")
+	if len(chain) != 2 || chain[0].GoName != "Resource" || chain[1].GoName != "Name" {
+		t.Fatalf("resolveFieldPath(outer, %q) = %v, want [Resource Name]", "resource.name", chain)
+	}
+
+	if chain := resolveFieldPath(outer, "id"); len(chain) != 1 || chain[0].GoName != "Id" {
+		t.Fatalf("resolveFieldPath(outer, %q) = %v, want [Id]", "id", chain)
+	}
+
+	if chain := resolveFieldPath(outer, "resource.missing"); chain != nil {
+		t.Fatalf("resolveFieldPath(outer, %q) = %v, want nil", "resource.missing", chain)
+	}
+
+	if chain := resolveFieldPath(outer, "id.name"); chain != nil {
+		t.Fatalf("resolveFieldPath(outer, %q) = %v, want nil (id is not a message)", "id.name", chain)
+	}
+}
@@ -19,6 +19,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"strconv"
 	"strings"
@@ -35,17 +36,38 @@ const (
 	statusPackage  = protogen.GoImportPath("google.golang.org/grpc/status")
 )
 
-// generateFile generates a _grpc.pb.go file containing gRPC service definitions.
-func generateFile(gen *protogen.Plugin, file *protogen.File) *protogen.GeneratedFile {
-	if len(file.Services) == 0 {
-		return nil
-	}
-	filename := file.GeneratedFilenamePrefix + "_grpc.pb.go"
-	g := gen.NewGeneratedFile(filename, file.GoImportPath)
+// useGenericStreams, when set, switches stream client/server type generation
+// away from the hand-rolled per-method structs and onto type aliases over
+// grpc-go's generic streaming interfaces (grpc.BidiStreamingClient,
+// grpc.ServerStreamingServer and the like), backed by grpc.GenericClientStream
+// / grpc.GenericServerStream values. This
+// drops the boilerplate Send/Recv/CloseAndRecv/SendAndClose methods from the
+// generated output. It is off by default to preserve existing wire/API compat
+// with generated code that predates generics support in grpc-go.
+var useGenericStreams = flag.Bool("use_generic_streams", false, "use generic streams")
+
+// newGRPCFile opens a generated file named file.GeneratedFilenamePrefix+suffix
+// and writes the header and package statement every generated file shares.
+func newGRPCFile(gen *protogen.Plugin, file *protogen.File, suffix string) *protogen.GeneratedFile {
+	g := gen.NewGeneratedFile(file.GeneratedFilenamePrefix+suffix, file.GoImportPath)
 	g.P("// Code generated by protoc-gen-go-grpc. DO NOT EDIT.")
 	g.P()
 	g.P("package ", file.GoPackageName)
 	g.P()
+	return g
+}
+
+// generateFile generates a _grpc.pb.go file containing gRPC service
+// definitions, or — when -split_files is set — a set of per-kind and
+// per-service files in its place (see generateSplitFiles).
+func generateFile(gen *protogen.Plugin, file *protogen.File) *protogen.GeneratedFile {
+	if len(file.Services) == 0 {
+		return nil
+	}
+	if *splitFiles {
+		return generateSplitFiles(gen, file)
+	}
+	g := newGRPCFile(gen, file, "_grpc.pb.go")
 	generateFileContent(gen, file, g)
 	return g
 }
@@ -56,18 +78,21 @@ func generateFileContent(gen *protogen.Plugin, file *protogen.File, g *protogen.
 		return
 	}
 
-	g.P("// This is a compile-time assertion to ensure that this generated file")
-	g.P("// is compatible with the grpc package it is being compiled against.")
-	g.P("const _ = ", grpcPackage.Ident("SupportPackageIsVersion7"))
+	backend := selectedBackend()
+	if !checkStreamingSupport(gen, file, backend) {
+		return
+	}
+	backend.SupportPackageAssertion(g)
 	g.P()
+	backend.EmitPreamble(g, file)
 	for _, service := range file.Services {
-		genClient(gen, file, g, service)
-		genService(gen, file, g, service)
+		genClient(gen, file, g, service, backend)
+		genService(gen, file, g, g, service, backend)
 		genUnstableServiceInterface(gen, file, g, service)
 	}
 }
 
-func genClient(gen *protogen.Plugin, file *protogen.File, g *protogen.GeneratedFile, service *protogen.Service) {
+func genClient(gen *protogen.Plugin, file *protogen.File, g *protogen.GeneratedFile, service *protogen.Service, backend TransportBackend) {
 	if *migrationMode {
 		return
 	}
@@ -90,14 +115,32 @@ func genClient(gen *protogen.Plugin, file *protogen.File, g *protogen.GeneratedF
 			g.P(deprecationComment)
 		}
 		g.P(method.Comments.Leading,
-			clientSignature(g, method))
+			clientSignature(g, method, backend))
 	}
 	g.P("}")
 	g.P()
 
+	if jsonConfig, ok := serviceConfigJSON(service); ok {
+		g.P("// ", service.GoName, "ServiceConfigJSON is the default gRPC service config")
+		g.P("// encoding the retry/hedging policy declared in the .proto via")
+		g.P("// grpc.method_policy, suitable for grpc.WithDefaultServiceConfig.")
+		g.P("const ", service.GoName, "ServiceConfigJSON = `", jsonConfig, "`")
+		g.P()
+
+		g.P("// Default", clientName, "DialOption applies ", service.GoName, "ServiceConfigJSON,")
+		g.P("// so the retry/hedging policy declared via grpc.method_policy takes effect")
+		g.P("// without callers having to pass it to grpc.Dial themselves. gRPC's own")
+		g.P("// retry/hedging implementation is driven entirely by the service config; there")
+		g.P("// is no separate per-call grpc.CallOption for it.")
+		g.P("func Default", clientName, "DialOption() ", grpcPackage.Ident("DialOption"), " {")
+		g.P("return ", grpcPackage.Ident("WithDefaultServiceConfig"), "(", service.GoName, "ServiceConfigJSON)")
+		g.P("}")
+		g.P()
+	}
+
 	// Client structure.
 	g.P("type ", unexport(clientName), " struct {")
-	g.P("cc ", grpcPackage.Ident("ClientConnInterface"))
+	g.P("cc ", backend.ConnType(g))
 	g.P("}")
 	g.P()
 
@@ -105,23 +148,23 @@ func genClient(gen *protogen.Plugin, file *protogen.File, g *protogen.GeneratedF
 	if service.Desc.Options().(*descriptorpb.ServiceOptions).GetDeprecated() {
 		g.P(deprecationComment)
 	}
-	g.P("func New", clientName, " (cc ", grpcPackage.Ident("ClientConnInterface"), ") ", clientName, " {")
+	g.P("func New", clientName, " (cc ", backend.ConnType(g), ") ", clientName, " {")
 	g.P("return &", unexport(clientName), "{cc}")
 	g.P("}")
 	g.P()
 
 	// Client method implementations.
 	for _, method := range service.Methods {
-		genClientMethod(gen, g, method)
+		genClientMethod(gen, g, method, backend)
 	}
 }
 
-func clientSignature(g *protogen.GeneratedFile, method *protogen.Method) string {
+func clientSignature(g *protogen.GeneratedFile, method *protogen.Method, backend TransportBackend) string {
 	s := method.GoName + "(ctx " + g.QualifiedGoIdent(contextPackage.Ident("Context"))
 	if !method.Desc.IsStreamingClient() {
 		s += ", in *" + g.QualifiedGoIdent(method.Input.GoIdent)
 	}
-	s += ", opts ..." + g.QualifiedGoIdent(grpcPackage.Ident("CallOption")) + ") ("
+	s += ", opts ..." + backend.CallOptionType(g) + ") ("
 	if !method.Desc.IsStreamingClient() && !method.Desc.IsStreamingServer() {
 		s += "*" + g.QualifiedGoIdent(method.Output.GoIdent)
 	} else {
@@ -131,7 +174,7 @@ func clientSignature(g *protogen.GeneratedFile, method *protogen.Method) string
 	return s
 }
 
-func genClientMethod(gen *protogen.Plugin, g *protogen.GeneratedFile, method *protogen.Method) {
+func genClientMethod(gen *protogen.Plugin, g *protogen.GeneratedFile, method *protogen.Method, backend TransportBackend) {
 	service := method.Parent
 	sname := fmt.Sprintf("/%s/%s", service.Desc.FullName(), method.Desc.Name())
 
@@ -150,10 +193,10 @@ func genClientMethod(gen *protogen.Plugin, g *protogen.GeneratedFile, method *pr
 	}
 	g.P("}")
 
-	g.P("func (c *", unexport(service.GoName), "Client) ", clientSignature(g, method), "{")
+	g.P("func (c *", unexport(service.GoName), "Client) ", clientSignature(g, method, backend), "{")
 	if !method.Desc.IsStreamingServer() && !method.Desc.IsStreamingClient() {
 		g.P("out := new(", method.Output.GoIdent, ")")
-		g.P(`err := c.cc.Invoke(ctx, "`, sname, `", in, out, opts...)`)
+		backend.Invoke(g, sname)
 		g.P("if err != nil { return nil, err }")
 		g.P("return out, nil")
 		g.P("}")
@@ -164,7 +207,11 @@ func genClientMethod(gen *protogen.Plugin, g *protogen.GeneratedFile, method *pr
 
 	g.P(`stream, err := c.cc.NewStream(ctx, `, streamDescName, `, "`, sname, `", opts...)`)
 	g.P("if err != nil { return nil, err }")
-	g.P("x := &", streamType, "{stream}")
+	if *useGenericStreams {
+		g.P("x := &", genericClientStreamIdent(g, method), "{ClientStream: stream}")
+	} else {
+		g.P("x := &", streamType, "{stream}")
+	}
 	if !method.Desc.IsStreamingClient() {
 		g.P("if err := x.ClientStream.SendMsg(in); err != nil { return nil, err }")
 		g.P("if err := x.ClientStream.CloseSend(); err != nil { return nil, err }")
@@ -173,6 +220,11 @@ func genClientMethod(gen *protogen.Plugin, g *protogen.GeneratedFile, method *pr
 	g.P("}")
 	g.P()
 
+	if *useGenericStreams {
+		genGenericClientStreamAlias(g, method)
+		return
+	}
+
 	genSend := method.Desc.IsStreamingClient()
 	genRecv := method.Desc.IsStreamingServer()
 	genCloseAndRecv := !method.Desc.IsStreamingServer()
@@ -222,7 +274,71 @@ func genClientMethod(gen *protogen.Plugin, g *protogen.GeneratedFile, method *pr
 	}
 }
 
-func genService(gen *protogen.Plugin, file *protogen.File, g *protogen.GeneratedFile, service *protogen.Service) {
+// genericClientStreamIdent returns the instantiated grpc.GenericClientStream
+// used to back the stream returned from the client method when generic
+// streams are enabled.
+func genericClientStreamIdent(g *protogen.GeneratedFile, method *protogen.Method) string {
+	return g.QualifiedGoIdent(grpcPackage.Ident("GenericClientStream")) +
+		"[" + g.QualifiedGoIdent(method.Input.GoIdent) + ", " + g.QualifiedGoIdent(method.Output.GoIdent) + "]"
+}
+
+// genGenericClientStreamAlias emits the Service_MethodClient type as an alias
+// over the generic streaming client interface matching the method's
+// client/server streaming shape, instead of generating a dedicated struct and
+// Send/Recv/CloseAndRecv methods.
+func genGenericClientStreamAlias(g *protogen.GeneratedFile, method *protogen.Method) {
+	service := method.Parent
+	name := service.GoName + "_" + method.GoName + "Client"
+	in, out := g.QualifiedGoIdent(method.Input.GoIdent), g.QualifiedGoIdent(method.Output.GoIdent)
+	switch {
+	case method.Desc.IsStreamingClient() && method.Desc.IsStreamingServer():
+		g.P("// ", name, " is the client API for ", method.GoName, " bidirectional streaming.")
+		g.P("type ", name, " = ", grpcPackage.Ident("BidiStreamingClient"), "[", in, ", ", out, "]")
+	case method.Desc.IsStreamingClient():
+		g.P("// ", name, " is the client API for ", method.GoName, " client streaming.")
+		g.P("type ", name, " = ", grpcPackage.Ident("ClientStreamingClient"), "[", in, ", ", out, "]")
+	default:
+		g.P("// ", name, " is the client API for ", method.GoName, " server streaming.")
+		g.P("type ", name, " = ", grpcPackage.Ident("ServerStreamingClient"), "[", out, "]")
+	}
+	g.P()
+}
+
+// genericServerStreamIdent returns the instantiated grpc.GenericServerStream
+// used to back the stream passed to the method handler when generic streams
+// are enabled.
+func genericServerStreamIdent(g *protogen.GeneratedFile, method *protogen.Method) string {
+	return g.QualifiedGoIdent(grpcPackage.Ident("GenericServerStream")) +
+		"[" + g.QualifiedGoIdent(method.Input.GoIdent) + ", " + g.QualifiedGoIdent(method.Output.GoIdent) + "]"
+}
+
+// genGenericServerStreamAlias emits the Service_MethodServer type as an alias
+// over the generic streaming server interface matching the method's
+// client/server streaming shape, instead of generating a dedicated struct and
+// Send/Recv/SendAndClose methods.
+func genGenericServerStreamAlias(g *protogen.GeneratedFile, method *protogen.Method) {
+	service := method.Parent
+	name := service.GoName + "_" + method.GoName + "Server"
+	in, out := g.QualifiedGoIdent(method.Input.GoIdent), g.QualifiedGoIdent(method.Output.GoIdent)
+	switch {
+	case method.Desc.IsStreamingClient() && method.Desc.IsStreamingServer():
+		g.P("// ", name, " is the server API for ", method.GoName, " bidirectional streaming.")
+		g.P("type ", name, " = ", grpcPackage.Ident("BidiStreamingServer"), "[", in, ", ", out, "]")
+	case method.Desc.IsStreamingClient():
+		g.P("// ", name, " is the server API for ", method.GoName, " client streaming.")
+		g.P("type ", name, " = ", grpcPackage.Ident("ClientStreamingServer"), "[", in, ", ", out, "]")
+	default:
+		g.P("// ", name, " is the server API for ", method.GoName, " server streaming.")
+		g.P("type ", name, " = ", grpcPackage.Ident("ServerStreamingServer"), "[", out, "]")
+	}
+	g.P()
+}
+
+// genService emits the server-side struct, handlers, registration and
+// constructor for service into g. Stream auxiliary types are emitted into
+// streamsFile instead, which is g itself unless -split_files has routed
+// them to a dedicated file.
+func genService(gen *protogen.Plugin, file *protogen.File, g *protogen.GeneratedFile, streamsFile *protogen.GeneratedFile, service *protogen.Service, backend TransportBackend) {
 	// Server struct.
 	serviceType := service.GoName + "Service"
 	g.P("// ", serviceType, " is the service API for ", service.GoName, " service.")
@@ -248,69 +364,21 @@ func genService(gen *protogen.Plugin, file *protogen.File, g *protogen.Generated
 
 	// Method handler implementations.
 	for _, method := range service.Methods {
-		genMethodHandler(gen, g, method)
+		genMethodHandler(gen, g, method, backend)
 	}
 
 	// Stream interfaces and implementations.
 	for _, method := range service.Methods {
-		genServerStreamTypes(gen, g, method)
+		genServerStreamTypes(gen, streamsFile, method)
 	}
 
 	// Service registration.
-	genRegisterFunction(gen, file, g, service)
+	backend.EmitRegister(g, file, service)
 
 	// Short-cut service constructor.
 	genServiceConstructor(gen, g, service)
 }
 
-func genRegisterFunction(gen *protogen.Plugin, file *protogen.File, g *protogen.GeneratedFile, service *protogen.Service) {
-	g.P("// Register", service.GoName, "Service registers a service implementation with a gRPC server.")
-	if service.Desc.Options().(*descriptorpb.ServiceOptions).GetDeprecated() {
-		g.P("//")
-		g.P(deprecationComment)
-	}
-	g.P("func Register", service.GoName, "Service(s ", grpcPackage.Ident("ServiceRegistrar"), ", srv *", service.GoName, "Service) {")
-
-	// Service descriptor.
-	g.P("sd := ", grpcPackage.Ident("ServiceDesc"), " {")
-	g.P("ServiceName: ", strconv.Quote(string(service.Desc.FullName())), ",")
-	g.P("Methods: []", grpcPackage.Ident("MethodDesc"), "{")
-	for _, method := range service.Methods {
-		if method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer() {
-			continue
-		}
-		g.P("{")
-		g.P("MethodName: ", strconv.Quote(string(method.Desc.Name())), ",")
-		g.P("Handler: srv.", unexport(method.GoName), ",")
-		g.P("},")
-	}
-	g.P("},")
-	g.P("Streams: []", grpcPackage.Ident("StreamDesc"), "{")
-	for _, method := range service.Methods {
-		if !method.Desc.IsStreamingClient() && !method.Desc.IsStreamingServer() {
-			continue
-		}
-		g.P("{")
-		g.P("StreamName: ", strconv.Quote(string(method.Desc.Name())), ",")
-		g.P("Handler: srv.", unexport(method.GoName), ",")
-		if method.Desc.IsStreamingServer() {
-			g.P("ServerStreams: true,")
-		}
-		if method.Desc.IsStreamingClient() {
-			g.P("ClientStreams: true,")
-		}
-		g.P("},")
-	}
-	g.P("},")
-	g.P("Metadata: \"", file.Desc.Path(), "\",")
-	g.P("}")
-	g.P()
-
-	g.P("s.RegisterService(&sd, nil)")
-	g.P("}")
-	g.P()
-}
-
 func genServiceConstructor(gen *protogen.Plugin, g *protogen.GeneratedFile, service *protogen.Service) {
 	g.P("// New", service.GoName, "Service creates a new ", service.GoName, "Service containing the")
 	g.P("// implemented methods of the ", service.GoName, " service in s.  Any unimplemented")
@@ -396,7 +464,7 @@ func streamHandlerSignature(g *protogen.GeneratedFile) string {
 	return "(_ interface{}, stream " + g.QualifiedGoIdent(grpcPackage.Ident("ServerStream")) + ") error"
 }
 
-func genMethodHandler(gen *protogen.Plugin, g *protogen.GeneratedFile, method *protogen.Method) {
+func genMethodHandler(gen *protogen.Plugin, g *protogen.GeneratedFile, method *protogen.Method, backend TransportBackend) {
 	service := method.Parent
 
 	nilArg := ""
@@ -408,7 +476,7 @@ func genMethodHandler(gen *protogen.Plugin, g *protogen.GeneratedFile, method *p
 	g.P("func (s *", service.GoName, "Service) ", unexport(method.GoName), signature, " {")
 
 	g.P("if s.", method.GoName, " == nil {")
-	g.P("return ", nilArg, statusPackage.Ident("Errorf"), "(", codesPackage.Ident("Unimplemented"), `, "method `, method.GoName, ` not implemented")`)
+	backend.Unimplemented(g, nilArg, method)
 	g.P("}")
 	genHandlerBody(gen, g, method)
 
@@ -432,14 +500,18 @@ func genHandlerBody(gen *protogen.Plugin, g *protogen.GeneratedFile, method *pro
 		return
 	}
 	streamType := unexport(service.GoName) + method.GoName + "Server"
+	streamIdent := "&" + streamType + "{stream}"
+	if *useGenericStreams {
+		streamIdent = "&" + genericServerStreamIdent(g, method) + "{ServerStream: stream}"
+	}
 	if !method.Desc.IsStreamingClient() {
 		// Server-streaming
 		g.P("m := new(", method.Input.GoIdent, ")")
 		g.P("if err := stream.RecvMsg(m); err != nil { return err }")
-		g.P("return s.", method.GoName, "(m, &", streamType, "{stream})")
+		g.P("return s.", method.GoName, "(m, ", streamIdent, ")")
 	} else {
 		// Bidi-streaming
-		g.P("return s.", method.GoName, "(&", streamType, "{stream})")
+		g.P("return s.", method.GoName, "(", streamIdent, ")")
 	}
 }
 
@@ -452,6 +524,10 @@ func genServerStreamTypes(gen *protogen.Plugin, g *protogen.GeneratedFile, metho
 		return
 	}
 	service := method.Parent
+	if *useGenericStreams {
+		genGenericServerStreamAlias(g, method)
+		return
+	}
 	streamType := unexport(service.GoName) + method.GoName + "Server"
 	genSend := method.Desc.IsStreamingServer()
 	genSendAndClose := !method.Desc.IsStreamingServer()
@@ -0,0 +1,108 @@
+/*
+ *
+ * Copyright 2020 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"strconv"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// grpcGoBackend is the TransportBackend reproducing protoc-gen-go-grpc's
+// original, grpc-go-specific output. It is the default backend.
+type grpcGoBackend struct{}
+
+func (grpcGoBackend) ConnType(g *protogen.GeneratedFile) string {
+	return g.QualifiedGoIdent(grpcPackage.Ident("ClientConnInterface"))
+}
+
+func (grpcGoBackend) CallOptionType(g *protogen.GeneratedFile) string {
+	return g.QualifiedGoIdent(grpcPackage.Ident("CallOption"))
+}
+
+func (grpcGoBackend) Invoke(g *protogen.GeneratedFile, sname string) {
+	g.P(`err := c.cc.Invoke(ctx, "`, sname, `", in, out, opts...)`)
+}
+
+func (grpcGoBackend) Unimplemented(g *protogen.GeneratedFile, nilArg string, method *protogen.Method) {
+	g.P("return ", nilArg, statusPackage.Ident("Errorf"), "(", codesPackage.Ident("Unimplemented"), `, "method `, method.GoName, ` not implemented")`)
+}
+
+func (grpcGoBackend) SupportPackageAssertion(g *protogen.GeneratedFile) {
+	g.P("// This is a compile-time assertion to ensure that this generated file")
+	g.P("// is compatible with the grpc package it is being compiled against.")
+	if *useGenericStreams {
+		g.P("const _ = ", grpcPackage.Ident("SupportPackageIsVersion9"))
+	} else {
+		g.P("const _ = ", grpcPackage.Ident("SupportPackageIsVersion7"))
+	}
+}
+
+func (grpcGoBackend) EmitPreamble(g *protogen.GeneratedFile, file *protogen.File) {}
+
+func (grpcGoBackend) SupportsStreaming() bool { return true }
+
+func (grpcGoBackend) EmitRegister(g *protogen.GeneratedFile, file *protogen.File, service *protogen.Service) {
+	g.P("// Register", service.GoName, "Service registers a service implementation with a gRPC server.")
+	if service.Desc.Options().(*descriptorpb.ServiceOptions).GetDeprecated() {
+		g.P("//")
+		g.P(deprecationComment)
+	}
+	g.P("func Register", service.GoName, "Service(s ", grpcPackage.Ident("ServiceRegistrar"), ", srv *", service.GoName, "Service) {")
+
+	// Service descriptor.
+	g.P("sd := ", grpcPackage.Ident("ServiceDesc"), " {")
+	g.P("ServiceName: ", strconv.Quote(string(service.Desc.FullName())), ",")
+	g.P("Methods: []", grpcPackage.Ident("MethodDesc"), "{")
+	for _, method := range service.Methods {
+		if method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer() {
+			continue
+		}
+		g.P("{")
+		g.P("MethodName: ", strconv.Quote(string(method.Desc.Name())), ",")
+		g.P("Handler: srv.", unexport(method.GoName), ",")
+		g.P("},")
+	}
+	g.P("},")
+	g.P("Streams: []", grpcPackage.Ident("StreamDesc"), "{")
+	for _, method := range service.Methods {
+		if !method.Desc.IsStreamingClient() && !method.Desc.IsStreamingServer() {
+			continue
+		}
+		g.P("{")
+		g.P("StreamName: ", strconv.Quote(string(method.Desc.Name())), ",")
+		g.P("Handler: srv.", unexport(method.GoName), ",")
+		if method.Desc.IsStreamingServer() {
+			g.P("ServerStreams: true,")
+		}
+		if method.Desc.IsStreamingClient() {
+			g.P("ClientStreams: true,")
+		}
+		g.P("},")
+	}
+	g.P("},")
+	g.P("Metadata: \"", file.Desc.Path(), "\",")
+	g.P("}")
+	g.P()
+
+	g.P("s.RegisterService(&sd, nil)")
+	g.P("}")
+	g.P()
+}
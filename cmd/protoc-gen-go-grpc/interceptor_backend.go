@@ -0,0 +1,107 @@
+/*
+ *
+ * Copyright 2020 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"strconv"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+const fmtPackage = protogen.GoImportPath("fmt")
+
+// interceptorPreambleEmitted tracks which Go packages have already received
+// interceptorBackend's Client/CallOption/Registrar declarations, since
+// EmitPreamble runs once per proto input file but those types must only be
+// declared once per Go package, or a package assembled from more than one
+// proto file fails to compile with "redeclared in this block".
+var interceptorPreambleEmitted = map[protogen.GoImportPath]bool{}
+
+// interceptorBackend is a TransportBackend for -backend=interceptor. It
+// targets non-grpc-go runtimes (a message bus, an in-process test double,
+// ...) by routing client calls through a narrow Client.Call abstraction and
+// server registration through a name-keyed Registrar, instead of grpc-go's
+// ClientConnInterface/ServiceRegistrar. It does not support streaming RPCs:
+// checkStreamingSupport rejects a file with a streaming method before this
+// backend can emit code for it.
+type interceptorBackend struct{}
+
+func (interceptorBackend) ConnType(g *protogen.GeneratedFile) string {
+	return "Client"
+}
+
+func (interceptorBackend) CallOptionType(g *protogen.GeneratedFile) string {
+	return "CallOption"
+}
+
+func (interceptorBackend) Invoke(g *protogen.GeneratedFile, sname string) {
+	g.P(`err := c.cc.Call(ctx, "`, sname, `", in, out, opts...)`)
+}
+
+func (interceptorBackend) Unimplemented(g *protogen.GeneratedFile, nilArg string, method *protogen.Method) {
+	g.P("return ", nilArg, fmtPackage.Ident("Errorf"), `("method `, method.GoName, ` not implemented")`)
+}
+
+func (interceptorBackend) SupportPackageAssertion(g *protogen.GeneratedFile) {
+	g.P("// This generated file targets the interceptor transport backend and")
+	g.P("// does not depend on a specific grpc-go release.")
+}
+
+// EmitPreamble declares the Client/CallOption/Registrar types every service
+// in file's Go package is generated against when -backend=interceptor is
+// selected. Real deployments supply their own implementations of these
+// (wrapping a message-bus client, an in-process router, etc.); none is
+// shipped here. The declaration is emitted at most once per Go package: a
+// package commonly spans several proto inputs, and EmitPreamble runs once
+// per input.
+func (interceptorBackend) EmitPreamble(g *protogen.GeneratedFile, file *protogen.File) {
+	if interceptorPreambleEmitted[file.GoImportPath] {
+		return
+	}
+	interceptorPreambleEmitted[file.GoImportPath] = true
+
+	g.P("// Client is the call surface the interceptor backend requires of a")
+	g.P("// transport, in place of grpc.ClientConnInterface. It does not support")
+	g.P("// streaming.")
+	g.P("type Client interface {")
+	g.P("Call(ctx ", contextPackage.Ident("Context"), ", method string, req, reply interface{}, opts ...CallOption) error")
+	g.P("}")
+	g.P()
+	g.P("// CallOption configures an individual Client.Call.")
+	g.P("type CallOption interface {")
+	g.P("applyCallOption()")
+	g.P("}")
+	g.P()
+	g.P("// Registrar is the service-registration surface the interceptor backend")
+	g.P("// requires of a transport, in place of grpc.ServiceRegistrar.")
+	g.P("type Registrar interface {")
+	g.P("RegisterService(name string, srv interface{})")
+	g.P("}")
+	g.P()
+}
+
+func (interceptorBackend) SupportsStreaming() bool { return false }
+
+func (interceptorBackend) EmitRegister(g *protogen.GeneratedFile, file *protogen.File, service *protogen.Service) {
+	g.P("// Register", service.GoName, "Service registers a service implementation with a Registrar.")
+	g.P("func Register", service.GoName, "Service(s Registrar, srv *", service.GoName, "Service) {")
+	g.P("s.RegisterService(", strconv.Quote(string(service.Desc.FullName())), ", srv)")
+	g.P("}")
+	g.P()
+}
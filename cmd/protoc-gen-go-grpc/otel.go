@@ -0,0 +1,295 @@
+/*
+ *
+ * Copyright 2020 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"flag"
+	"strconv"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+const (
+	tracePackage  = protogen.GoImportPath("go.opentelemetry.io/otel/trace")
+	metricPackage = protogen.GoImportPath("go.opentelemetry.io/otel/metric")
+	attrPackage   = protogen.GoImportPath("go.opentelemetry.io/otel/attribute")
+	timePackage   = protogen.GoImportPath("time")
+)
+
+// otel, when set, makes generateOTelFile emit a _grpc.otel.go sibling with
+// OpenTelemetry-instrumented client and server decorators, so users get
+// per-RPC and per-message tracing without hand-wiring interceptors.
+var otel = flag.Bool("otel", false, "emit OpenTelemetry-instrumented client/server wrappers")
+
+// generateOTelFile generates a _grpc.otel.go file containing
+// FooClientWithTracing/FooServerWithTracing decorators for every service in
+// file, following the OpenTelemetry semantic conventions for RPC.
+func generateOTelFile(gen *protogen.Plugin, file *protogen.File) *protogen.GeneratedFile {
+	if !*otel || len(file.Services) == 0 {
+		return nil
+	}
+	filename := file.GeneratedFilenamePrefix + "_grpc.otel.go"
+	g := gen.NewGeneratedFile(filename, file.GoImportPath)
+	g.P("// Code generated by protoc-gen-go-grpc. DO NOT EDIT.")
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+	for _, service := range file.Services {
+		genOTelClient(g, service)
+		genOTelServer(g, service)
+	}
+	return g
+}
+
+func spanName(method *protogen.Method) string {
+	return string(method.Parent.Desc.FullName()) + "/" + string(method.Desc.Name())
+}
+
+func genRPCAttributes(g *protogen.GeneratedFile, method *protogen.Method) {
+	g.P(attrPackage.Ident("String"), `("rpc.system", "grpc"),`)
+	g.P(attrPackage.Ident("String"), `("rpc.service", `, strconv.Quote(string(method.Parent.Desc.FullName())), `),`)
+	g.P(attrPackage.Ident("String"), `("rpc.method", `, strconv.Quote(string(method.Desc.Name())), `),`)
+}
+
+func genOTelClient(g *protogen.GeneratedFile, service *protogen.Service) {
+	backend := selectedBackend()
+	clientName := service.GoName + "Client"
+	wrapperType := unexport(clientName) + "Tracing"
+	pkgName := strconv.Quote(string(service.Desc.ParentFile().Package()))
+
+	g.P("// ", clientName, "WithTracing wraps inner so every call starts a span named")
+	g.P(`// "`, service.Desc.FullName(), `/<Method>" carrying the OpenTelemetry RPC semantic`)
+	g.P("// convention attributes, and records call duration and per-message stream events.")
+	g.P("func ", clientName, "WithTracing(inner ", clientName, ", tp ", tracePackage.Ident("TracerProvider"), ", mp ", metricPackage.Ident("MeterProvider"), ") ", clientName, " {")
+	g.P("tracer := tp.Tracer(", pkgName, ")")
+	g.P("duration, _ := mp.Meter(", pkgName, ").Float64Histogram(\"rpc.client.duration\")")
+	g.P("return &", wrapperType, "{inner: inner, tracer: tracer, duration: duration}")
+	g.P("}")
+	g.P()
+
+	g.P("type ", wrapperType, " struct {")
+	g.P("inner ", clientName)
+	g.P("tracer ", tracePackage.Ident("Tracer"))
+	g.P("duration ", metricPackage.Ident("Float64Histogram"))
+	g.P("}")
+	g.P()
+
+	for _, method := range service.Methods {
+		genOTelClientMethod(g, method, wrapperType, backend)
+	}
+}
+
+func genOTelClientMethod(g *protogen.GeneratedFile, method *protogen.Method, wrapperType string, backend TransportBackend) {
+	g.P("func (c *", wrapperType, ") ", clientSignature(g, method, backend), "{")
+	g.P("ctx, span := c.tracer.Start(ctx, ", strconv.Quote(spanName(method)), ", ", tracePackage.Ident("WithAttributes"), "(")
+	genRPCAttributes(g, method)
+	g.P("))")
+
+	if !method.Desc.IsStreamingClient() && !method.Desc.IsStreamingServer() {
+		g.P("start := ", timePackage.Ident("Now"), "()")
+		g.P("out, err := c.inner.", method.GoName, "(ctx, in, opts...)")
+		g.P("st, _ := ", statusPackage.Ident("FromError"), "(err)")
+		g.P("span.SetAttributes(", attrPackage.Ident("Int"), `("rpc.grpc.status_code", int(st.Code())))`)
+		g.P("span.End()")
+		g.P("if c.duration != nil { c.duration.Record(ctx, ", timePackage.Ident("Since"), "(start).Seconds()) }")
+		g.P("return out, err")
+		g.P("}")
+		g.P()
+		return
+	}
+
+	streamType := unexport(method.Parent.GoName) + method.GoName + "ClientTracingStream"
+	args := "(ctx, opts...)"
+	if !method.Desc.IsStreamingClient() {
+		args = "(ctx, in, opts...)"
+	}
+	g.P("start := ", timePackage.Ident("Now"), "()")
+	g.P("stream, err := c.inner.", method.GoName, args)
+	g.P("if err != nil {")
+	g.P("span.End()")
+	g.P("if c.duration != nil { c.duration.Record(ctx, ", timePackage.Ident("Since"), "(start).Seconds()) }")
+	g.P("return nil, err")
+	g.P("}")
+	g.P("return &", streamType, "{", method.Parent.GoName, "_", method.GoName, "Client: stream, span: span, ctx: ctx, start: start, duration: c.duration}, nil")
+	g.P("}")
+	g.P()
+
+	genSend := method.Desc.IsStreamingClient()
+	genRecv := method.Desc.IsStreamingServer()
+	genCloseAndRecv := !method.Desc.IsStreamingServer()
+
+	g.P("type ", streamType, " struct {")
+	g.P(method.Parent.GoName, "_", method.GoName, "Client")
+	g.P("span ", tracePackage.Ident("Span"))
+	g.P("ctx ", contextPackage.Ident("Context"))
+	g.P("start ", timePackage.Ident("Time"))
+	g.P("duration ", metricPackage.Ident("Float64Histogram"))
+	g.P("sent, recv int64")
+	g.P("}")
+	g.P()
+
+	if genSend {
+		g.P("func (x *", streamType, ") Send(m *", method.Input.GoIdent, ") error {")
+		g.P("err := x.", method.Parent.GoName, "_", method.GoName, "Client.Send(m)")
+		g.P("if err == nil {")
+		g.P("x.sent++")
+		g.P("x.span.AddEvent(\"message\", ", tracePackage.Ident("WithAttributes"), "(", attrPackage.Ident("String"), `("message.type", "SENT"), `, attrPackage.Ident("Int64"), `("message.id", x.sent)))`)
+		g.P("}")
+		g.P("return err")
+		g.P("}")
+		g.P()
+	}
+	if genRecv {
+		g.P("func (x *", streamType, ") Recv() (*", method.Output.GoIdent, ", error) {")
+		g.P("m, err := x.", method.Parent.GoName, "_", method.GoName, "Client.Recv()")
+		g.P("if err != nil {")
+		g.P("x.span.End()")
+		g.P("if x.duration != nil { x.duration.Record(x.ctx, ", timePackage.Ident("Since"), "(x.start).Seconds()) }")
+		g.P("return m, err")
+		g.P("}")
+		g.P("x.recv++")
+		g.P("x.span.AddEvent(\"message\", ", tracePackage.Ident("WithAttributes"), "(", attrPackage.Ident("String"), `("message.type", "RECEIVED"), `, attrPackage.Ident("Int64"), `("message.id", x.recv)))`)
+		g.P("return m, err")
+		g.P("}")
+		g.P()
+	}
+	if genCloseAndRecv {
+		g.P("func (x *", streamType, ") CloseAndRecv() (*", method.Output.GoIdent, ", error) {")
+		g.P("m, err := x.", method.Parent.GoName, "_", method.GoName, "Client.CloseAndRecv()")
+		g.P("x.span.End()")
+		g.P("if x.duration != nil { x.duration.Record(x.ctx, ", timePackage.Ident("Since"), "(x.start).Seconds()) }")
+		g.P("return m, err")
+		g.P("}")
+		g.P()
+	}
+}
+
+func genOTelServer(g *protogen.GeneratedFile, service *protogen.Service) {
+	serviceType := service.GoName + "Service"
+	pkgName := strconv.Quote(string(service.Desc.ParentFile().Package()))
+
+	g.P("// ", serviceType, "WithTracing returns a copy of inner whose assigned handlers")
+	g.P("// are wrapped to start a span per call following the OpenTelemetry RPC")
+	g.P("// semantic conventions, and to record server-side call duration.")
+	g.P("func ", serviceType, "WithTracing(inner *", serviceType, ", tp ", tracePackage.Ident("TracerProvider"), ", mp ", metricPackage.Ident("MeterProvider"), ") *", serviceType, " {")
+	g.P("tracer := tp.Tracer(", pkgName, ")")
+	g.P("duration, _ := mp.Meter(", pkgName, ").Float64Histogram(\"rpc.server.duration\")")
+	g.P("wrapped := &", serviceType, "{}")
+	for _, method := range service.Methods {
+		genOTelServerMethod(g, method)
+	}
+	g.P("return wrapped")
+	g.P("}")
+	g.P()
+}
+
+func genOTelServerMethod(g *protogen.GeneratedFile, method *protogen.Method) {
+	service := method.Parent
+	g.P("if h := inner.", method.GoName, "; h != nil {")
+	if !method.Desc.IsStreamingClient() && !method.Desc.IsStreamingServer() {
+		g.P("wrapped.", method.GoName, " = func(ctx ", contextPackage.Ident("Context"), ", in *", method.Input.GoIdent, ") (*", method.Output.GoIdent, ", error) {")
+		g.P("ctx, span := tracer.Start(ctx, ", strconv.Quote(spanName(method)), ", ", tracePackage.Ident("WithAttributes"), "(")
+		genRPCAttributes(g, method)
+		g.P("))")
+		g.P("start := ", timePackage.Ident("Now"), "()")
+		g.P("out, err := h(ctx, in)")
+		g.P("st, _ := ", statusPackage.Ident("FromError"), "(err)")
+		g.P("span.SetAttributes(", attrPackage.Ident("Int"), `("rpc.grpc.status_code", int(st.Code())))`)
+		g.P("span.End()")
+		g.P("if duration != nil { duration.Record(ctx, ", timePackage.Ident("Since"), "(start).Seconds()) }")
+		g.P("return out, err")
+		g.P("}")
+		g.P("}")
+		g.P()
+		return
+	}
+
+	streamType := unexport(service.GoName) + method.GoName + "ServerTracingStream"
+	if !method.Desc.IsStreamingClient() {
+		g.P("wrapped.", method.GoName, " = func(m *", method.Input.GoIdent, ", stream ", service.GoName, "_", method.GoName, "Server) error {")
+		g.P("_, span := tracer.Start(stream.Context(), ", strconv.Quote(spanName(method)), ", ", tracePackage.Ident("WithAttributes"), "(")
+		genRPCAttributes(g, method)
+		g.P("))")
+		g.P("defer span.End()")
+		g.P("start := ", timePackage.Ident("Now"), "()")
+		g.P("err := h(m, &", streamType, "{", service.GoName, "_", method.GoName, "Server: stream, span: span})")
+		g.P("if duration != nil { duration.Record(stream.Context(), ", timePackage.Ident("Since"), "(start).Seconds()) }")
+		g.P("st, _ := ", statusPackage.Ident("FromError"), "(err)")
+		g.P("span.SetAttributes(", attrPackage.Ident("Int"), `("rpc.grpc.status_code", int(st.Code())))`)
+		g.P("return err")
+		g.P("}")
+		g.P("}")
+		g.P()
+	} else {
+		g.P("wrapped.", method.GoName, " = func(stream ", service.GoName, "_", method.GoName, "Server) error {")
+		g.P("_, span := tracer.Start(stream.Context(), ", strconv.Quote(spanName(method)), ", ", tracePackage.Ident("WithAttributes"), "(")
+		genRPCAttributes(g, method)
+		g.P("))")
+		g.P("defer span.End()")
+		g.P("start := ", timePackage.Ident("Now"), "()")
+		g.P("err := h(&", streamType, "{", service.GoName, "_", method.GoName, "Server: stream, span: span})")
+		g.P("if duration != nil { duration.Record(stream.Context(), ", timePackage.Ident("Since"), "(start).Seconds()) }")
+		g.P("st, _ := ", statusPackage.Ident("FromError"), "(err)")
+		g.P("span.SetAttributes(", attrPackage.Ident("Int"), `("rpc.grpc.status_code", int(st.Code())))`)
+		g.P("return err")
+		g.P("}")
+		g.P("}")
+		g.P()
+	}
+
+	genSend := method.Desc.IsStreamingServer()
+	genSendAndClose := !method.Desc.IsStreamingServer()
+	genRecv := method.Desc.IsStreamingClient()
+
+	g.P("type ", streamType, " struct {")
+	g.P(service.GoName, "_", method.GoName, "Server")
+	g.P("span ", tracePackage.Ident("Span"))
+	g.P("sent, recv int64")
+	g.P("}")
+	g.P()
+
+	if genSend {
+		g.P("func (x *", streamType, ") Send(m *", method.Output.GoIdent, ") error {")
+		g.P("err := x.", service.GoName, "_", method.GoName, "Server.Send(m)")
+		g.P("if err == nil {")
+		g.P("x.sent++")
+		g.P("x.span.AddEvent(\"message\", ", tracePackage.Ident("WithAttributes"), "(", attrPackage.Ident("String"), `("message.type", "SENT"), `, attrPackage.Ident("Int64"), `("message.id", x.sent)))`)
+		g.P("}")
+		g.P("return err")
+		g.P("}")
+		g.P()
+	}
+	if genSendAndClose {
+		g.P("func (x *", streamType, ") SendAndClose(m *", method.Output.GoIdent, ") error {")
+		g.P("return x.", service.GoName, "_", method.GoName, "Server.SendAndClose(m)")
+		g.P("}")
+		g.P()
+	}
+	if genRecv {
+		g.P("func (x *", streamType, ") Recv() (*", method.Input.GoIdent, ", error) {")
+		g.P("m, err := x.", service.GoName, "_", method.GoName, "Server.Recv()")
+		g.P("if err == nil {")
+		g.P("x.recv++")
+		g.P("x.span.AddEvent(\"message\", ", tracePackage.Ident("WithAttributes"), "(", attrPackage.Ident("String"), `("message.type", "RECEIVED"), `, attrPackage.Ident("Int64"), `("message.id", x.recv)))`)
+		g.P("}")
+		g.P("return m, err")
+		g.P("}")
+		g.P()
+	}
+}
@@ -0,0 +1,277 @@
+/*
+ *
+ * Copyright 2020 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"math"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// methodPolicyFieldNumber is the field number of the grpc.method_policy
+// custom MethodOptions extension.
+const methodPolicyFieldNumber protowire.Number = 80001
+
+type retryPolicy struct {
+	MaxAttempts          int
+	InitialBackoff       string
+	MaxBackoff           string
+	BackoffMultiplier    float64
+	RetryableStatusCodes []string
+}
+
+type hedgingPolicy struct {
+	MaxAttempts         int
+	HedgingDelay        string
+	NonFatalStatusCodes []string
+}
+
+type methodPolicy struct {
+	Retry   *retryPolicy
+	Hedging *hedgingPolicy
+}
+
+// methodPolicyFor decodes the grpc.method_policy custom MethodOptions
+// extension (field 80001) directly out of the option's unrecognized wire
+// bytes. Reading it this way, instead of depending on a generated extension
+// type, keeps this plugin's only proto dependency on descriptorpb/protowire
+// rather than requiring a companion .proto to have been compiled into this
+// module ahead of time.
+func methodPolicyFor(method *protogen.Method) (*methodPolicy, bool) {
+	opts, ok := method.Desc.Options().(*descriptorpb.MethodOptions)
+	if !ok || opts == nil {
+		return nil, false
+	}
+	b, ok := consumeField(opts.ProtoReflect().GetUnknown(), methodPolicyFieldNumber)
+	if !ok {
+		return nil, false
+	}
+	mp := &methodPolicy{}
+	if rb, ok := consumeField(b, 1); ok {
+		mp.Retry = parseRetryPolicy(rb)
+	}
+	if hb, ok := consumeField(b, 2); ok {
+		mp.Hedging = parseHedgingPolicy(hb)
+	}
+	if mp.Retry == nil && mp.Hedging == nil {
+		return nil, false
+	}
+	return mp, true
+}
+
+// consumeField scans b for the first occurrence of field want and returns
+// the payload of its length-delimited value.
+func consumeField(b []byte, want protowire.Number) ([]byte, bool) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, false
+		}
+		b = b[n:]
+		if num != want || typ != protowire.BytesType {
+			m := protowire.ConsumeFieldValue(num, typ, b)
+			if m < 0 {
+				return nil, false
+			}
+			b = b[m:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return nil, false
+		}
+		return v, true
+	}
+	return nil, false
+}
+
+// consumeStrings returns every occurrence of field want decoded as a string.
+func consumeStrings(b []byte, want protowire.Number) []string {
+	var out []string
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return out
+		}
+		b = b[n:]
+		if num != want || typ != protowire.BytesType {
+			m := protowire.ConsumeFieldValue(num, typ, b)
+			if m < 0 {
+				return out
+			}
+			b = b[m:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return out
+		}
+		out = append(out, string(v))
+		b = b[n:]
+	}
+	return out
+}
+
+func consumeVarintField(b []byte, want protowire.Number) (int, bool) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return 0, false
+		}
+		b = b[n:]
+		if num != want || typ != protowire.VarintType {
+			m := protowire.ConsumeFieldValue(num, typ, b)
+			if m < 0 {
+				return 0, false
+			}
+			b = b[m:]
+			continue
+		}
+		v, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return 0, false
+		}
+		return int(v), true
+	}
+	return 0, false
+}
+
+func consumeStringField(b []byte, want protowire.Number) (string, bool) {
+	v, ok := consumeField(b, want)
+	return string(v), ok
+}
+
+func consumeDoubleField(b []byte, want protowire.Number) (float64, bool) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return 0, false
+		}
+		b = b[n:]
+		if num != want || typ != protowire.Fixed64Type {
+			m := protowire.ConsumeFieldValue(num, typ, b)
+			if m < 0 {
+				return 0, false
+			}
+			b = b[m:]
+			continue
+		}
+		v, n := protowire.ConsumeFixed64(b)
+		if n < 0 {
+			return 0, false
+		}
+		return math.Float64frombits(v), true
+	}
+	return 0, false
+}
+
+func parseRetryPolicy(b []byte) *retryPolicy {
+	rp := &retryPolicy{RetryableStatusCodes: consumeStrings(b, 5)}
+	rp.MaxAttempts, _ = consumeVarintField(b, 1)
+	rp.InitialBackoff, _ = consumeStringField(b, 2)
+	rp.MaxBackoff, _ = consumeStringField(b, 3)
+	rp.BackoffMultiplier, _ = consumeDoubleField(b, 4)
+	return rp
+}
+
+func parseHedgingPolicy(b []byte) *hedgingPolicy {
+	hp := &hedgingPolicy{NonFatalStatusCodes: consumeStrings(b, 3)}
+	hp.MaxAttempts, _ = consumeVarintField(b, 1)
+	hp.HedgingDelay, _ = consumeStringField(b, 2)
+	return hp
+}
+
+// serviceConfigMethodName/serviceConfigRetryPolicy/serviceConfigHedgingPolicy
+// mirror the JSON shape of a gRPC method config entry, see
+// https://github.com/grpc/grpc/blob/master/doc/service_config.md.
+type serviceConfigMethodName struct {
+	Service string `json:"service"`
+	Method  string `json:"method,omitempty"`
+}
+
+type serviceConfigRetryPolicy struct {
+	MaxAttempts          int      `json:"maxAttempts"`
+	InitialBackoff       string   `json:"initialBackoff"`
+	MaxBackoff           string   `json:"maxBackoff"`
+	BackoffMultiplier    float64  `json:"backoffMultiplier"`
+	RetryableStatusCodes []string `json:"retryableStatusCodes"`
+}
+
+type serviceConfigHedgingPolicy struct {
+	MaxAttempts         int      `json:"maxAttempts"`
+	HedgingDelay        string   `json:"hedgingDelay"`
+	NonFatalStatusCodes []string `json:"nonFatalStatusCodes,omitempty"`
+}
+
+type serviceConfigMethod struct {
+	Name          []serviceConfigMethodName   `json:"name"`
+	RetryPolicy   *serviceConfigRetryPolicy   `json:"retryPolicy,omitempty"`
+	HedgingPolicy *serviceConfigHedgingPolicy `json:"hedgingPolicy,omitempty"`
+}
+
+type serviceConfig struct {
+	MethodConfig []serviceConfigMethod `json:"methodConfig"`
+}
+
+// serviceConfigJSON builds the grpc.WithDefaultServiceConfig-compatible JSON
+// document encoding every method_policy found on service's methods. It
+// returns false if no method carries the extension.
+func serviceConfigJSON(service *protogen.Service) (string, bool) {
+	var cfg serviceConfig
+	for _, method := range service.Methods {
+		policy, ok := methodPolicyFor(method)
+		if !ok {
+			continue
+		}
+		mc := serviceConfigMethod{
+			Name: []serviceConfigMethodName{{
+				Service: string(service.Desc.FullName()),
+				Method:  string(method.Desc.Name()),
+			}},
+		}
+		if policy.Retry != nil {
+			mc.RetryPolicy = &serviceConfigRetryPolicy{
+				MaxAttempts:          policy.Retry.MaxAttempts,
+				InitialBackoff:       policy.Retry.InitialBackoff,
+				MaxBackoff:           policy.Retry.MaxBackoff,
+				BackoffMultiplier:    policy.Retry.BackoffMultiplier,
+				RetryableStatusCodes: policy.Retry.RetryableStatusCodes,
+			}
+		}
+		if policy.Hedging != nil {
+			mc.HedgingPolicy = &serviceConfigHedgingPolicy{
+				MaxAttempts:         policy.Hedging.MaxAttempts,
+				HedgingDelay:        policy.Hedging.HedgingDelay,
+				NonFatalStatusCodes: policy.Hedging.NonFatalStatusCodes,
+			}
+		}
+		cfg.MethodConfig = append(cfg.MethodConfig, mc)
+	}
+	if len(cfg.MethodConfig) == 0 {
+		return "", false
+	}
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
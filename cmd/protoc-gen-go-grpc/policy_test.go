@@ -0,0 +1,114 @@
+/*
+ *
+ * Copyright 2020 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestConsumeField(t *testing.T) {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, 7)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendBytes(b, []byte("payload"))
+
+	got, ok := consumeField(b, 2)
+	if !ok || string(got) != "payload" {
+		t.Fatalf("consumeField(_, 2) = %q, %v, want %q, true", got, ok, "payload")
+	}
+
+	if _, ok := consumeField(b, 3); ok {
+		t.Fatalf("consumeField(_, 3) matched an absent field")
+	}
+}
+
+func TestConsumeStrings(t *testing.T) {
+	var b []byte
+	b = protowire.AppendTag(b, 5, protowire.BytesType)
+	b = protowire.AppendBytes(b, []byte("UNAVAILABLE"))
+	b = protowire.AppendTag(b, 5, protowire.BytesType)
+	b = protowire.AppendBytes(b, []byte("DEADLINE_EXCEEDED"))
+
+	got := consumeStrings(b, 5)
+	want := []string{"UNAVAILABLE", "DEADLINE_EXCEEDED"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("consumeStrings(_, 5) = %v, want %v", got, want)
+	}
+
+	if got := consumeStrings(b, 9); got != nil {
+		t.Fatalf("consumeStrings(_, 9) = %v, want nil", got)
+	}
+}
+
+func TestConsumeVarintField(t *testing.T) {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, 5)
+
+	got, ok := consumeVarintField(b, 1)
+	if !ok || got != 5 {
+		t.Fatalf("consumeVarintField(_, 1) = %d, %v, want 5, true", got, ok)
+	}
+
+	if _, ok := consumeVarintField(b, 2); ok {
+		t.Fatalf("consumeVarintField(_, 2) matched an absent field")
+	}
+}
+
+func TestConsumeDoubleField(t *testing.T) {
+	var b []byte
+	b = protowire.AppendTag(b, 4, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(1.6))
+
+	got, ok := consumeDoubleField(b, 4)
+	if !ok || got != 1.6 {
+		t.Fatalf("consumeDoubleField(_, 4) = %v, %v, want 1.6, true", got, ok)
+	}
+}
+
+func TestParseRetryPolicy(t *testing.T) {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, 4)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendBytes(b, []byte("0.1s"))
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	b = protowire.AppendBytes(b, []byte("1s"))
+	b = protowire.AppendTag(b, 4, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(2))
+	b = protowire.AppendTag(b, 5, protowire.BytesType)
+	b = protowire.AppendBytes(b, []byte("UNAVAILABLE"))
+
+	got := parseRetryPolicy(b)
+	want := &retryPolicy{
+		MaxAttempts:          4,
+		InitialBackoff:       "0.1s",
+		MaxBackoff:           "1s",
+		BackoffMultiplier:    2,
+		RetryableStatusCodes: []string{"UNAVAILABLE"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseRetryPolicy() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+ *
+ * Copyright 2020 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"flag"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// splitFiles, when set, routes generateFile's output across dedicated
+// per-kind and per-service files instead of a single _grpc.pb.go. This keeps
+// incremental rebuilds cheap for proto files with hundreds of RPCs, and lets
+// client-only binaries gitignore or otherwise avoid pulling in server stubs.
+var splitFiles = flag.Bool("split_files", false, "split generated output across per-kind and per-service files")
+
+// generateSplitFiles generates _grpc.client.pb.go, _grpc.server.pb.go and
+// _grpc.streams.pb.go, holding every service's client, server and
+// server-side stream output respectively (client-side stream types are
+// emitted by genClient into the client file, next to the methods that
+// return them), plus one _grpc.<service>.pb.go per service holding
+// that service's UnstableFooService interface. genClient, genService and
+// genServerStreamTypes are routed to the file that matches their kind; the
+// iteration order over file.Services and service.Methods is fixed by the
+// proto's declaration order, so output is byte-identical across runs for a
+// given input.
+func generateSplitFiles(gen *protogen.Plugin, file *protogen.File) *protogen.GeneratedFile {
+	backend := selectedBackend()
+	if !checkStreamingSupport(gen, file, backend) {
+		return nil
+	}
+
+	clientFile := newGRPCFile(gen, file, "_grpc.client.pb.go")
+	backend.SupportPackageAssertion(clientFile)
+	clientFile.P()
+	backend.EmitPreamble(clientFile, file)
+
+	serverFile := newGRPCFile(gen, file, "_grpc.server.pb.go")
+	backend.SupportPackageAssertion(serverFile)
+	serverFile.P()
+
+	streamsFile := newGRPCFile(gen, file, "_grpc.streams.pb.go")
+	backend.SupportPackageAssertion(streamsFile)
+	streamsFile.P()
+
+	for _, service := range file.Services {
+		genClient(gen, file, clientFile, service, backend)
+		genService(gen, file, serverFile, streamsFile, service, backend)
+
+		serviceFile := newGRPCFile(gen, file, "_grpc."+strings.ToLower(service.GoName)+".pb.go")
+		genUnstableServiceInterface(gen, file, serviceFile, service)
+	}
+
+	return clientFile
+}